@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
 	"strings"
+	"sync"
+	"time"
 )
 
 import "github.com/jjneely/buckytools/hashing"
@@ -22,11 +25,85 @@ type ClusterConfig struct {
 	// that the cluster is using
 	Hash hashing.HashRing
 
-	// Healthy is true if the cluster configuration represents a Healthy
-	// cluster
+	// Health is the result of the last peer discovery pass: one entry
+	// per peer buckyd daemon plus an aggregate verdict.
+	Health ClusterHealth
+
+	// RingHash is the canonical hash of the ring configuration observed
+	// from the initial buckyd daemon at discovery time.  Long-running
+	// tools (rebalance, backfill) should re-fetch the ring periodically
+	// and compare against this value, aborting rather than silently
+	// migrating data against a ring that changed mid-run.
+	RingHash string
+}
+
+// defaultClusterTimeout bounds how long GetClusterConfig will wait on
+// the whole cluster discovery pass when the caller doesn't supply its
+// own context.
+const defaultClusterTimeout = 10 * time.Second
+
+// Peer fetch tuning: each peer's ring is fetched concurrently, bounded
+// by peerWorkerLimit workers, with up to peerMaxAttempts tries and
+// exponential backoff starting at peerRetryBaseDelay between them.
+const (
+	peerWorkerLimit    = 16
+	peerMaxAttempts    = 3
+	peerRetryBaseDelay = 100 * time.Millisecond
+)
+
+// PeerHealth describes the result of fetching a single peer buckyd's
+// ring configuration during cluster discovery.
+type PeerHealth struct {
+	// HostPort is the peer that was queried.
+	HostPort string
+
+	// Reachable is true if the peer answered within peerMaxAttempts
+	// tries and the context deadline.
+	Reachable bool
+
+	// Algo is the hash algorithm the peer reported, if Reachable.
+	Algo string
+
+	// NodeMismatch is true if the peer's algorithm or node list
+	// disagrees with the initial daemon's.
+	NodeMismatch bool
+
+	// OrderMismatch is true if the peer's node list has the same
+	// members as the initial daemon's but in a different order.
+	OrderMismatch bool
+
+	// LastError is the error from the final attempt, if not Reachable.
+	LastError error
+
+	// Latency is how long the (possibly retried) fetch took.
+	Latency time.Duration
+}
+
+// ClusterHealth is the aggregate result of a cluster discovery pass.
+type ClusterHealth struct {
+	// Peers holds one PeerHealth per peer buckyd daemon that was
+	// queried, in no particular order.
+	Peers []PeerHealth
+
+	// Healthy is true only if every peer was Reachable and agreed with
+	// the initial daemon's ring.
 	Healthy bool
 }
 
+// Displaced returns the number of metrics that have been placed on a
+// Node other than their primary owner because of bounded-load
+// rebalancing.  Clusters not using a "*_bounded" hash algorithm always
+// report zero.
+func (c *ClusterConfig) Displaced() int {
+	if c == nil {
+		return 0
+	}
+	if b, ok := c.Hash.(*hashing.BoundedLoadHashRing); ok {
+		return b.Displaced()
+	}
+	return 0
+}
+
 // Cluster is the working and cached cluster configuration
 var Cluster *ClusterConfig
 
@@ -43,19 +120,35 @@ func (c *ClusterConfig) HostPorts() []string {
 
 // GetClusterConfig returns either the cached ClusterConfig object or
 // builds it if needed.  The initial HOST:PORT of the buckyd daemon
-// must be given.
+// must be given.  It is a thin wrapper around GetClusterConfigContext
+// that bounds discovery to defaultClusterTimeout.
 func GetClusterConfig(hostport string) (*ClusterConfig, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultClusterTimeout)
+	defer cancel()
+	return GetClusterConfigContext(ctx, hostport)
+}
+
+// GetClusterConfigContext returns either the cached ClusterConfig object
+// or builds it if needed, the same as GetClusterConfig, but fetches each
+// peer's ring concurrently and stops waiting on peers once ctx is done:
+// peers that have not answered by then are recorded as unreachable in
+// Cluster.Health, and the ClusterConfig is still returned.  It returns
+// an error only if the initial daemon can't be reached.  This keeps
+// bucky CLI startup fast in large clusters: the old implementation
+// fetched peers one at a time, so discovery was O(N) in cluster size and
+// a single slow or down peer stalled every command.
+func GetClusterConfigContext(ctx context.Context, hostport string) (*ClusterConfig, error) {
 	if Cluster != nil {
 		return Cluster, nil
 	}
 
-	master, err := GetSingleHashRing(hostport)
+	master, _, err := fetchRingWithRetry(ctx, hostport)
 	if err != nil {
 		log.Printf("Abort: Cannot communicate with initial buckyd daemon.")
 		return nil, err
 	}
 
-	server, port, err := net.SplitHostPort(HostPort)
+	server, port, err := net.SplitHostPort(hostport)
 	if err != nil {
 		log.Printf("Abort: Invalid host:port representation: %s", hostport)
 		return nil, err
@@ -64,11 +157,22 @@ func GetClusterConfig(hostport string) (*ClusterConfig, error) {
 	Cluster = new(ClusterConfig)
 	Cluster.Port = port
 	Cluster.Servers = make([]string, 0)
+	Cluster.RingHash = master.RingHash
 	switch master.Algo {
 	case "carbon":
 		Cluster.Hash = hashing.NewCarbonHashRing()
 	case "jump_fnv1a":
 		Cluster.Hash = hashing.NewJumpHashRing(master.Replicas)
+	case "carbon_bounded":
+		// NewBoundedLoadHashRing treats a zero or negative epsilon as
+		// "not set" and falls back to hashing.DefaultEpsilon, so older
+		// buckyd daemons that omit Epsilon from the ring JSON still get
+		// a sane cap.
+		Cluster.Hash = hashing.NewBoundedLoadHashRing(hashing.NewCarbonHashRing(), master.Epsilon)
+	case "jump_bounded":
+		Cluster.Hash = hashing.NewBoundedLoadHashRing(hashing.NewJumpHashRing(master.Replicas), master.Epsilon)
+	case "partitioned":
+		Cluster.Hash = hashing.NewPartitionedHashRing(master.PartitionExponent)
 	default:
 		log.Printf("Unknown consistent hash algorithm: %s", master.Algo)
 		return nil, fmt.Errorf("Unknown consistent hash algorithm: %s", master.Algo)
@@ -84,63 +188,198 @@ func GetClusterConfig(hostport string) (*ClusterConfig, error) {
 		Cluster.Hash.AddNode(hashing.Node{fields[0], fields[1]})
 	}
 
-	members := make([]*JSONRingType, 0)
+	peers := make([]string, 0, len(Cluster.Servers))
 	for _, srv := range Cluster.Servers {
-		if srv == server {
-			// Don't query the initial daemon again
+		if srv != server {
+			peers = append(peers, srv)
+		}
+	}
+
+	peerHosts := make([]string, len(peers))
+	peerHealth := make([]PeerHealth, len(peers))
+	reported := make([]bool, len(peers))
+	for i, srv := range peers {
+		peerHosts[i] = fmt.Sprintf("%s:%s", srv, Cluster.Port)
+		peerHealth[i] = PeerHealth{HostPort: peerHosts[i]}
+	}
+
+	sem := make(chan struct{}, peerWorkerLimit)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	for i, host := range peerHosts {
+		wg.Add(1)
+		go func(i int, host string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			ph := PeerHealth{HostPort: host}
+
+			member, latency, err := fetchRingWithRetry(ctx, host)
+			ph.Latency = latency
+			if err != nil {
+				ph.LastError = err
+				log.Printf("Cluster unhealthy: %s: %s", host, err)
+			} else {
+				ph.Reachable = true
+				ph.Algo = member.Algo
+				ph.NodeMismatch, ph.OrderMismatch = diffPeer(master, member)
+			}
+
+			mu.Lock()
+			peerHealth[i] = ph
+			reported[i] = true
+			mu.Unlock()
+		}(i, host)
+	}
+
+	// Don't let a single peer goroutine block past ctx's deadline: race
+	// wg.Wait() against ctx.Done() so a hung GetSingleHashRing (which
+	// fetchRingWithRetry only bounds between attempts, not during the
+	// call itself) can't stall the whole discovery pass.
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("Cluster discovery timed out waiting on peers: %s", ctx.Err())
+	}
+
+	// Publish our own copy of the peer results rather than the slice the
+	// goroutines above write into: any peer that hasn't reported in yet
+	// (only possible on the ctx.Done() branch) is marked unreachable here
+	// instead of being left at its Reachable: false, Healthy-looking
+	// zero value, and straggler goroutines that finish later mutate
+	// peerHealth/reported, not the published ClusterHealth, so readers of
+	// Cluster.Health never race with them.
+	mu.Lock()
+	finalPeers := make([]PeerHealth, len(peerHealth))
+	copy(finalPeers, peerHealth)
+	stillReported := make([]bool, len(reported))
+	copy(stillReported, reported)
+	mu.Unlock()
+
+	healthy := true
+	for i := range finalPeers {
+		if !stillReported[i] {
+			finalPeers[i].LastError = fmt.Errorf("timed out waiting for peer: %s", ctx.Err())
+			healthy = false
 			continue
 		}
-		host := fmt.Sprintf("%s:%s", srv, Cluster.Port)
-		member, err := GetSingleHashRing(host)
-		if err != nil {
-			log.Printf("Cluster unhealthy: %s: %s", server, err)
+		if !finalPeers[i].Reachable || finalPeers[i].NodeMismatch || finalPeers[i].OrderMismatch {
+			healthy = false
 		}
-		members = append(members, member)
 	}
 
-	Cluster.Healthy = isHealthy(master, members)
+	Cluster.Health = ClusterHealth{Peers: finalPeers, Healthy: healthy}
 	return Cluster, nil
 }
 
-// isHealthy will return true if the cluster ring data represents
-// a healthy cluster.  The master is the initial buckyd daemon we
-// built the list from.
-func isHealthy(master *JSONRingType, ring []*JSONRingType) bool {
-
-  /*
-    I'm not even sure that replicas are part of the carbon-cache.py
-    hash ring implementation. I'm nullifying this check by equating the
-    'masters' length of nodes to the length of the hash ring.
-
-    As far as I can tell, this doesn't affect core functionality.
-  */
-	// XXX: Take replicas into account
-	if len(master.Nodes) != len(ring) {
-	  log.Printf("The length of master nodes is not equal to a hash ring+1, therefore the cluster is unhealthy")
-    log.Printf("The length of the masters nodes is: %s", len(master.Nodes))
-    log.Printf("The length of the ring is: %s", len(ring)+1)
-		return false
-	}
-
-	// We compare each ring to the first one
-	for _, v := range ring {
-		// Order, host:instance pair, must be the same.  You configured
-		// your cluster with a CM tool, right?
-		if master.Algo != v.Algo {
-		  log.Printf("Cluster is unhealthy because of unmatched algorithms between %s and %s", master)
-			return false
+// fetchRingWithRetry calls GetSingleHashRing against hostport, making
+// up to peerMaxAttempts attempts in all with exponential backoff
+// between them if it fails.  It
+// gives up early if ctx is done.  The returned duration covers every
+// attempt, not just the last one, so callers can see the true cost of a
+// flaky peer.
+func fetchRingWithRetry(ctx context.Context, hostport string) (*JSONRingType, time.Duration, error) {
+	start := time.Now()
+	var lastErr error
+
+	for attempt := 0; attempt < peerMaxAttempts; attempt++ {
+		if attempt > 0 {
+			delay := peerRetryBaseDelay * time.Duration(1<<uint(attempt-1))
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, time.Since(start), ctx.Err()
+			}
 		}
-		if len(v.Nodes) != len(master.Nodes) {
-		  log.Printf("Cluster is unhealthy because the number of reported nodes is different between %s and %s", master, v)
-			return false
+
+		select {
+		case <-ctx.Done():
+			return nil, time.Since(start), ctx.Err()
+		default:
 		}
-		for j, _ := range v.Nodes {
-			if v.Nodes[j] != master.Nodes[j] {
-			  log.Printf("Cluster is unhealthy because the node order is different between %s and %s", master, v)
-				return false
-			}
+
+		ring, err := GetSingleHashRing(hostport)
+		if err == nil {
+			return ring, time.Since(start), nil
+		}
+		lastErr = err
+	}
+
+	return nil, time.Since(start), lastErr
+}
+
+// diffPeer compares a single peer's reported ring against the master
+// (the initial buckyd daemon we built the cluster from) and reports
+// whether they disagree on ring membership or ring order.
+//
+//   - nodeMismatch is true if the peer uses a different hash algorithm,
+//     a different partition exponent (for "partitioned"), a different
+//     load epsilon (for the "*_bounded" algorithms), or a different set
+//     of nodes than master.
+//   - orderMismatch is true if the peer and master agree on membership
+//     but list the nodes in a different order.  You configured your
+//     cluster with a CM tool, right?
+//
+// If both sides reported a RingHash, diffPeer compares that first: a
+// match short-circuits the rest of the checks.  RingHash sorts the node
+// list, so when both sides report one, a peer that lists the same nodes
+// in a different order has a matching hash and is not reported as an
+// orderMismatch.  A RingHash mismatch logs
+// before falling through to the field-by-field diff so the log message
+// can name which field actually differs.  hashing.RingHash folds in
+// PartitionExponent and Epsilon along with Algo/Replicas/Nodes, so the
+// short-circuit can't miss a mismatch in those fields the way a hash of
+// just Algo/Replicas/Nodes would.
+func diffPeer(master, v *JSONRingType) (nodeMismatch, orderMismatch bool) {
+	if master.RingHash != "" && v.RingHash != "" {
+		if master.RingHash == v.RingHash {
+			return false, false
+		}
+		log.Printf("Peer ring hash differs from master (master=%s peer=%s), diffing fields", master.RingHash, v.RingHash)
+	}
+
+	if master.Algo != v.Algo {
+		log.Printf("Peer disagrees on hash algorithm: master=%s peer=%s", master.Algo, v.Algo)
+		return true, false
+	}
+	if master.Algo == "partitioned" && master.PartitionExponent != v.PartitionExponent {
+		log.Printf("Peer disagrees on partition exponent: master=%d peer=%d", master.PartitionExponent, v.PartitionExponent)
+		return true, false
+	}
+	if (master.Algo == "carbon_bounded" || master.Algo == "jump_bounded") && master.Epsilon != v.Epsilon {
+		log.Printf("Peer disagrees on load epsilon: master=%f peer=%f", master.Epsilon, v.Epsilon)
+		return true, false
+	}
+	if len(v.Nodes) != len(master.Nodes) {
+		log.Printf("Peer disagrees on node count: master=%d peer=%d", len(master.Nodes), len(v.Nodes))
+		return true, false
+	}
+
+	members := make(map[string]int, len(master.Nodes))
+	for _, n := range master.Nodes {
+		members[n]++
+	}
+	for _, n := range v.Nodes {
+		if members[n] == 0 {
+			log.Printf("Peer disagrees on node membership: %s is not in master's ring", n)
+			return true, false
+		}
+		members[n]--
+	}
+
+	for j := range v.Nodes {
+		if v.Nodes[j] != master.Nodes[j] {
+			return false, true
 		}
 	}
 
-	return true
+	return false, false
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+)
+
+import "github.com/jjneely/buckytools/hashing"
+
+// RebalanceReport summarizes the result of resolving a batch of metric
+// placements against the cluster's hash ring.
+type RebalanceReport struct {
+	// Placements maps each metric to the Node it was resolved to.
+	Placements map[string]hashing.Node
+
+	// Total is the number of metrics placements was resolved for.
+	Total int
+
+	// Displaced is the cumulative number of metrics Cluster.Hash has
+	// placed on a Node other than their primary ring owner since the
+	// cluster configuration was built, per ClusterConfig.Displaced.
+	// Always zero unless the cluster is using a "*_bounded" hash
+	// algorithm.
+	Displaced int
+}
+
+// Rebalance resolves a destination Node for every metric in metrics
+// against Cluster.Hash.  inventory is the current metric -> Node
+// assignment already on disk, gathered by an earlier inventory pass; if
+// Cluster.Hash supports bounded loads, inventory is used to prime its
+// per-node load counters before any placement is resolved, so the first
+// metric looked up sees the real cluster load rather than an empty ring.
+//
+// Placements are resolved one at a time and, for bounded-load rings, fed
+// back into the load counters immediately so later lookups in the same
+// call see an up-to-date picture.  Callers (the rebalance/backfill
+// commands) use RebalanceReport.Displaced to tell operators how many
+// metrics moved off their primary owner during the run.
+func Rebalance(inventory map[string]hashing.Node, metrics []string) (*RebalanceReport, error) {
+	if Cluster == nil || Cluster.Hash == nil {
+		return nil, fmt.Errorf("Rebalance: no cluster configuration loaded")
+	}
+
+	bounded, isBounded := Cluster.Hash.(*hashing.BoundedLoadHashRing)
+	if isBounded {
+		counts := make(map[hashing.Node]int)
+		for _, n := range inventory {
+			counts[n]++
+		}
+		for n, count := range counts {
+			bounded.SetLoad(n, count)
+		}
+	}
+
+	report := &RebalanceReport{
+		Placements: make(map[string]hashing.Node, len(metrics)),
+	}
+
+	for _, metric := range metrics {
+		node := Cluster.Hash.GetNode(metric)
+		report.Placements[metric] = node
+		report.Total++
+		if isBounded {
+			bounded.IncrLoad(node)
+		}
+	}
+
+	report.Displaced = Cluster.Displaced()
+	return report, nil
+}
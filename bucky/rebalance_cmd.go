@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+import "github.com/jjneely/buckytools/hashing"
+import . "github.com/jjneely/buckytools"
+
+// RebalanceCommand is the "bucky rebalance" subcommand entry point.  It
+// loads a metric inventory from a "metric,server[:instance]" CSV file
+// (produced by an earlier `bucky list` pass), resolves every metric in
+// it against the cluster's hash ring via Rebalance, and prints how many
+// metrics were displaced from their primary owner.
+func RebalanceCommand(args []string) int {
+	fs := flag.NewFlagSet("rebalance", flag.ExitOnError)
+	inventoryPath := fs.String("i", "", "Path to a metric,server[:instance] CSV inventory")
+	fs.Parse(args)
+
+	if *inventoryPath == "" {
+		log.Printf("rebalance: -i <inventory file> is required")
+		return 1
+	}
+
+	if _, err := GetClusterConfig(HostPort); err != nil {
+		log.Printf("rebalance: %s", err)
+		return 1
+	}
+
+	inventory, metrics, err := readInventory(*inventoryPath)
+	if err != nil {
+		log.Printf("rebalance: %s", err)
+		return 1
+	}
+
+	report, err := Rebalance(inventory, metrics)
+	if err != nil {
+		log.Printf("rebalance: %s", err)
+		return 1
+	}
+
+	if changed, err := ringChanged(); err != nil {
+		log.Printf("rebalance: could not verify the ring hasn't changed: %s", err)
+		return 1
+	} else if changed {
+		log.Printf("Abort: ring configuration changed while rebalance was running, not trusting these placements")
+		return 1
+	}
+
+	fmt.Printf("%d metrics resolved, %d displaced from their primary owner\n", report.Total, report.Displaced)
+	for metric, node := range report.Placements {
+		fmt.Printf("%s -> %s\n", metric, node.Server)
+	}
+
+	return 0
+}
+
+// readInventory parses a "metric,server[:instance]" CSV file into the
+// map Rebalance expects, plus the ordered list of metric names found.
+func readInventory(path string) (map[string]hashing.Node, []string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	inventory := make(map[string]hashing.Node)
+	metrics := make([]string, 0)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, ",", 2)
+		if len(fields) != 2 {
+			return nil, nil, fmt.Errorf("malformed inventory line: %q", line)
+		}
+
+		metric := fields[0]
+		server, instance := fields[1], ""
+		if idx := strings.Index(fields[1], ":"); idx >= 0 {
+			server, instance = fields[1][:idx], fields[1][idx+1:]
+		}
+
+		inventory[metric] = hashing.Node{Server: server, Instance: instance}
+		metrics = append(metrics, metric)
+	}
+
+	return inventory, metrics, scanner.Err()
+}
+
+// ringChanged re-fetches the initial daemon's ring and reports whether
+// its RingHash no longer matches Cluster.RingHash, meaning the ring
+// changed after GetClusterConfig built Cluster.Hash — the exact
+// situation that can make a just-computed rebalance plan migrate
+// metrics to the wrong destinations.  Clusters whose buckyd doesn't
+// advertise a RingHash can't detect this and always report unchanged.
+func ringChanged() (bool, error) {
+	if Cluster == nil || Cluster.RingHash == "" {
+		return false, nil
+	}
+
+	ring, err := GetSingleHashRing(HostPort)
+	if err != nil {
+		return false, err
+	}
+	if ring.RingHash == "" {
+		return false, nil
+	}
+
+	return ring.RingHash != Cluster.RingHash, nil
+}
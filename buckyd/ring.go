@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+
+	. "github.com/jjneely/buckytools"
+	"github.com/jjneely/buckytools/hashing"
+)
+
+// Algo, Replicas and Nodes describe this daemon's view of the cluster
+// hash ring.  They're set from command line flags at startup and served
+// to peers and the bucky CLI by hashRingHandler.
+var (
+	Algo     string
+	Replicas int
+	Nodes    []string
+
+	// Epsilon is the load slack factor advertised for the "*_bounded"
+	// hash algorithms.  Zero means "not configured"; GetClusterConfig
+	// falls back to hashing.DefaultEpsilon in that case.
+	Epsilon float64
+
+	// PartitionExponent is the partition count, as a power of two,
+	// advertised for the "partitioned" hash algorithm.  Zero means "not
+	// configured"; GetClusterConfig falls back to
+	// hashing.DefaultPartitionExponent in that case.
+	PartitionExponent int
+)
+
+func init() {
+	flag.StringVar(&Algo, "algo", "carbon", "Consistent hashing algorithm to advertise")
+	flag.IntVar(&Replicas, "replicas", 1, "Replica count for hash algorithms that use it")
+	flag.Float64Var(&Epsilon, "epsilon", 0, "Load slack factor for the bounded-load hash algorithms")
+	flag.IntVar(&PartitionExponent, "partition-exponent", 0, "Partition count (as a power of two) for the partitioned hash algorithm")
+}
+
+// hashRingHandler serves this daemon's ring configuration as JSON so
+// peers and the bucky CLI can discover the cluster topology.
+func hashRingHandler(w http.ResponseWriter, r *http.Request) {
+	ring := &JSONRingType{
+		Algo:              Algo,
+		Replicas:          Replicas,
+		Nodes:             Nodes,
+		Epsilon:           Epsilon,
+		PartitionExponent: PartitionExponent,
+	}
+	ring.RingHash = hashing.RingHash(ring.Algo, ring.Replicas, ring.PartitionExponent, ring.Epsilon, ring.Nodes)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ring)
+}
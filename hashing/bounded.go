@@ -0,0 +1,128 @@
+package hashing
+
+// DefaultEpsilon is the load slack factor used when a buckyd ring doesn't
+// advertise one.  A node may carry up to avg * (1 + DefaultEpsilon)
+// metrics before placements spill over to the next candidate.
+const DefaultEpsilon = 0.25
+
+// BoundedLoadHashRing wraps another HashRing and implements Consistent
+// Hashing with Bounded Loads on top of it: a key's primary Node is found
+// via the wrapped ring, but if that Node is already overloaded relative
+// to the cluster average, placement walks the ring's candidate list
+// until it finds a Node under the cap.
+//
+// Callers must prime per-node load (typically via an inventory pass over
+// the metrics already on disk) with SetLoad/IncrLoad before GetNode will
+// produce meaningful results; a BoundedLoadHashRing with no recorded
+// load behaves exactly like the ring it wraps.
+type BoundedLoadHashRing struct {
+	ring    HashRing
+	epsilon float64
+
+	loads     map[Node]int
+	total     int
+	displaced int
+}
+
+// NewBoundedLoadHashRing returns a HashRing that enforces Consistent
+// Hashing with Bounded Loads over ring using the given epsilon.  A zero
+// or negative epsilon is replaced with DefaultEpsilon.
+func NewBoundedLoadHashRing(ring HashRing, epsilon float64) *BoundedLoadHashRing {
+	if epsilon <= 0 {
+		epsilon = DefaultEpsilon
+	}
+
+	return &BoundedLoadHashRing{
+		ring:    ring,
+		epsilon: epsilon,
+		loads:   make(map[Node]int),
+	}
+}
+
+// AddNode adds a Node to the underlying ring.  The Node starts with zero
+// recorded load.
+func (b *BoundedLoadHashRing) AddNode(n Node) {
+	b.ring.AddNode(n)
+	if _, ok := b.loads[n]; !ok {
+		b.loads[n] = 0
+	}
+}
+
+// RemoveNode removes a Node from the underlying ring and drops its load
+// counter.
+func (b *BoundedLoadHashRing) RemoveNode(n Node) {
+	b.ring.RemoveNode(n)
+	b.total -= b.loads[n]
+	delete(b.loads, n)
+}
+
+// Nodes returns every Node in the underlying ring.
+func (b *BoundedLoadHashRing) Nodes() []Node {
+	return b.ring.Nodes()
+}
+
+// SetLoad records the current number of metrics owned by n, overwriting
+// any previously recorded value.  Rebalance/backfill tooling calls this
+// once per Node after an inventory pass so GetNode has an accurate
+// picture of the cluster before resolving any placements.
+func (b *BoundedLoadHashRing) SetLoad(n Node, count int) {
+	b.total += count - b.loads[n]
+	b.loads[n] = count
+}
+
+// IncrLoad records the placement of one additional metric on n.  Callers
+// resolving a batch of placements should call IncrLoad after each
+// GetNode so later lookups in the same pass see the updated load.
+func (b *BoundedLoadHashRing) IncrLoad(n Node) {
+	b.loads[n]++
+	b.total++
+}
+
+// cap returns the maximum load a Node may carry before GetNode skips it
+// in favor of the next candidate.
+func (b *BoundedLoadHashRing) cap() float64 {
+	n := len(b.ring.Nodes())
+	if n == 0 {
+		return 0
+	}
+	avg := float64(b.total) / float64(n)
+	return avg * (1 + b.epsilon)
+}
+
+// GetNode returns the Node that should own key: the ring's primary Node
+// if it has capacity, otherwise the next candidate in ring order that
+// does.  If every candidate is at or over the cap, the primary Node is
+// returned so placement still succeeds.
+func (b *BoundedLoadHashRing) GetNode(key string) Node {
+	candidates := b.ring.GetNodes(key, len(b.ring.Nodes()))
+	if len(candidates) == 0 {
+		return Node{}
+	}
+
+	cap := b.cap()
+	for i, n := range candidates {
+		if float64(b.loads[n]) < cap {
+			if i > 0 {
+				b.displaced++
+			}
+			return n
+		}
+	}
+
+	return candidates[0]
+}
+
+// GetNodes defers to the wrapped ring; bounded load placement only
+// changes which single Node a key resolves to, not the full candidate
+// ordering used for replication.
+func (b *BoundedLoadHashRing) GetNodes(key string, count int) []Node {
+	return b.ring.GetNodes(key, count)
+}
+
+// Displaced returns the number of metrics that GetNode has placed on a
+// Node other than the ring's primary owner since this BoundedLoadHashRing
+// was created.  Rebalance/backfill tooling reports this so operators can
+// see how much a migration spread load away from the unbounded ring.
+func (b *BoundedLoadHashRing) Displaced() int {
+	return b.displaced
+}
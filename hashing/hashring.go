@@ -0,0 +1,37 @@
+// Package hashing implements the various consistent hashing strategies
+// that buckyd and the bucky CLI use to map a Graphite metric key to the
+// node(s) responsible for storing it.
+package hashing
+
+// Node represents a single buckyd server in the hash ring.  Instance
+// allows multiple buckyd processes to be distinguished on the same
+// Server, mirroring the "server:instance" notation used on the wire.
+type Node struct {
+	Server   string
+	Instance string
+}
+
+// HashRing is implemented by each consistent hashing algorithm that
+// buckytools supports.  A HashRing maps arbitrary metric keys to the
+// Node(s) that own them.
+type HashRing interface {
+	// AddNode adds a Node to the ring.
+	AddNode(n Node)
+
+	// RemoveNode removes a Node from the ring.
+	RemoveNode(n Node)
+
+	// Nodes returns every Node currently in the ring.
+	Nodes() []Node
+
+	// GetNode returns the Node responsible for the given metric key.
+	GetNode(key string) Node
+
+	// GetNodes returns up to count candidate Nodes for the given metric
+	// key, ordered by ring preference.  The first entry is the ring's
+	// primary owner, which is what GetNode returns for every
+	// implementation except BoundedLoadHashRing, whose GetNode may pick a
+	// later candidate.  Implementations that don't support replicas may return
+	// fewer than count Nodes.
+	GetNodes(key string, count int) []Node
+}
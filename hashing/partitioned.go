@@ -0,0 +1,180 @@
+package hashing
+
+import (
+	"hash/fnv"
+	"math"
+	"sort"
+)
+
+// DefaultPartitionExponent is used when a buckyd ring doesn't advertise
+// one.  2^5 == 32 partitions is enough to keep rebalance granularity
+// reasonable without growing the partition table unreasonably large.
+const DefaultPartitionExponent = 5
+
+// PartitionedHashRing implements a fixed-size partition ring modeled on
+// the hash ring used by OpenStack Ironic/Nova: the key space is sliced
+// into 2^exponent partitions, each owned by exactly one Node, and a
+// lookup is a binary search over the partition boundaries followed by a
+// partition -> Node map access. Adding or removing a Node only reassigns
+// the partitions that Node owned, so rebalances move roughly 1/N of the
+// ring's data instead of reshuffling the whole keyspace.
+//
+// The partition count is fixed at ring creation time, so exponent must
+// be sized for the largest node count the ring will ever hold: once
+// len(Nodes()) exceeds 2^exponent, partitions can no longer be split
+// evenly and some nodes will end up owning none at all.
+type PartitionedHashRing struct {
+	exponent   int
+	partitions []uint32 // sorted partition upper bounds
+	owners     map[uint32]Node
+	nodes      []Node
+}
+
+// NewPartitionedHashRing returns a HashRing with 2^exponent partitions.
+// exponent must be >= 1; values <= 0 fall back to
+// DefaultPartitionExponent.  Choose exponent so 2^exponent is comfortably
+// >= the number of Nodes the ring will ever hold — see the type doc.
+func NewPartitionedHashRing(exponent int) *PartitionedHashRing {
+	if exponent <= 0 {
+		exponent = DefaultPartitionExponent
+	}
+
+	count := 1 << uint(exponent)
+	partitions := make([]uint32, count)
+	width := uint64(math.MaxUint32+1) / uint64(count)
+	for i := range partitions {
+		partitions[i] = uint32(uint64(i+1)*width - 1)
+	}
+	partitions[count-1] = math.MaxUint32
+
+	return &PartitionedHashRing{
+		exponent:   exponent,
+		partitions: partitions,
+		owners:     make(map[uint32]Node),
+	}
+}
+
+// Exponent returns the partition exponent this ring was built with.
+func (p *PartitionedHashRing) Exponent() int {
+	return p.exponent
+}
+
+// AddNode adds n to the ring and assigns it an even share of the
+// partitions, pulling partitions away from whichever existing Nodes are
+// still above the new per-node target until n reaches it.  If the ring
+// has no Nodes yet, n receives every partition.
+func (p *PartitionedHashRing) AddNode(n Node) {
+	for _, existing := range p.nodes {
+		if existing == n {
+			return
+		}
+	}
+	p.nodes = append(p.nodes, n)
+
+	// target can truncate to 0 once len(nodes) > len(partitions); clamp
+	// to 1 so the reassignment loop below still only steals partitions
+	// from nodes that have more than their fair share, instead of
+	// treating every owned partition as fair game and re-looting
+	// whichever partition sorts first on every call (which left some
+	// nodes flipping between owning exactly one partition and zero).
+	target := len(p.partitions) / len(p.nodes)
+	if target < 1 {
+		target = 1
+	}
+	counts := make(map[Node]int)
+	for _, idx := range p.partitions {
+		counts[p.owners[idx]]++
+	}
+
+	for _, idx := range p.partitions {
+		owner, ok := p.owners[idx]
+		if ok && counts[owner] <= target {
+			continue
+		}
+		p.owners[idx] = n
+		if ok {
+			counts[owner]--
+		}
+		counts[n]++
+		if counts[n] >= target {
+			break
+		}
+	}
+}
+
+// RemoveNode removes n from the ring and hands its partitions to the
+// remaining Nodes in round-robin order.
+func (p *PartitionedHashRing) RemoveNode(n Node) {
+	kept := p.nodes[:0:0]
+	for _, existing := range p.nodes {
+		if existing != n {
+			kept = append(kept, existing)
+		}
+	}
+	p.nodes = kept
+
+	if len(p.nodes) == 0 {
+		p.owners = make(map[uint32]Node)
+		return
+	}
+
+	i := 0
+	for _, idx := range p.partitions {
+		if p.owners[idx] == n {
+			p.owners[idx] = p.nodes[i%len(p.nodes)]
+			i++
+		}
+	}
+}
+
+// Nodes returns every Node currently in the ring.
+func (p *PartitionedHashRing) Nodes() []Node {
+	return p.nodes
+}
+
+// partitionFor returns the index into p.partitions that owns key.
+func (p *PartitionedHashRing) partitionFor(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	sum := h.Sum32()
+
+	return sort.Search(len(p.partitions), func(i int) bool {
+		return p.partitions[i] >= sum
+	})
+}
+
+// GetNode returns the Node that owns key's partition.
+func (p *PartitionedHashRing) GetNode(key string) Node {
+	if len(p.nodes) == 0 {
+		return Node{}
+	}
+	idx := p.partitionFor(key)
+	return p.owners[p.partitions[idx]]
+}
+
+// GetNodes returns up to count Nodes for key, starting with the owning
+// Node and then walking the partition table forward, skipping Nodes
+// already seen, until count distinct Nodes have been collected or the
+// ring is exhausted.
+func (p *PartitionedHashRing) GetNodes(key string, count int) []Node {
+	if len(p.nodes) == 0 {
+		return nil
+	}
+	if count > len(p.nodes) {
+		count = len(p.nodes)
+	}
+
+	seen := make(map[Node]bool)
+	ret := make([]Node, 0, count)
+	start := p.partitionFor(key)
+	for i := 0; i < len(p.partitions) && len(ret) < count; i++ {
+		idx := p.partitions[(start+i)%len(p.partitions)]
+		n := p.owners[idx]
+		if !seen[n] {
+			seen[n] = true
+			ret = append(ret, n)
+		}
+	}
+
+	return ret
+}
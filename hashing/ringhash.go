@@ -0,0 +1,40 @@
+package hashing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RingHash returns a canonical, stable identifier for a ring
+// configuration: the SHA-256 hex digest of the hash algorithm, replica
+// count, partition exponent, load epsilon, and node list (sorted, so
+// reporting order never affects the result). buckyd computes this once
+// per ring and serves it alongside the rest of the ring JSON; clients
+// use it to compare two rings in O(1) instead of diffing every field.
+//
+// partitionExponent and epsilon are folded in so two rings that only
+// differ in one of those (e.g. the same "partitioned" algorithm and node
+// list but a different PartitionExponent) still hash differently —
+// otherwise a field that isn't part of Algo/Replicas/Nodes could change
+// out from under clients that only check RingHash.
+func RingHash(algo string, replicas int, partitionExponent int, epsilon float64, nodes []string) string {
+	sorted := make([]string, len(nodes))
+	copy(sorted, nodes)
+	sort.Strings(sorted)
+
+	h := sha256.New()
+	h.Write([]byte(algo))
+	h.Write([]byte("|"))
+	h.Write([]byte(strconv.Itoa(replicas)))
+	h.Write([]byte("|"))
+	h.Write([]byte(strconv.Itoa(partitionExponent)))
+	h.Write([]byte("|"))
+	h.Write([]byte(strconv.FormatFloat(epsilon, 'f', -1, 64)))
+	h.Write([]byte("|"))
+	h.Write([]byte(strings.Join(sorted, ",")))
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,67 @@
+package buckytools
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// HostPort is the HOST:PORT of the buckyd daemon the bucky CLI talks to
+// by default.  Set from the -h/--host command line flag.
+var HostPort string
+
+// JSONRingType is the wire representation of a buckyd's hash ring, as
+// served from the /hashring endpoint and consumed by GetClusterConfig.
+type JSONRingType struct {
+	// Algo is the consistent hashing algorithm this buckyd is using.
+	Algo string
+
+	// Replicas is the number of replicas given to hash algorithms that
+	// support them (currently jump_fnv1a and its _bounded variant).
+	Replicas int
+
+	// Nodes is the ordered list of "server[:instance]" members of the
+	// ring.
+	Nodes []string
+
+	// Epsilon is the load slack factor for the "*_bounded" algorithms.
+	// Zero means the daemon didn't set one; GetClusterConfig falls back
+	// to hashing.DefaultEpsilon in that case.
+	Epsilon float64
+
+	// PartitionExponent is the partition count, as a power of two, for
+	// the "partitioned" algorithm.  Zero means the daemon didn't set
+	// one; GetClusterConfig falls back to
+	// hashing.DefaultPartitionExponent in that case.
+	PartitionExponent int
+
+	// RingHash is the canonical hash of this ring's configuration, as
+	// computed by hashing.RingHash.  Comparing RingHash is an O(1)
+	// substitute for diffing Algo/Replicas/PartitionExponent/Nodes
+	// field-by-field, except that Nodes is hashed sorted, so node order
+	// is not covered; an empty string means the daemon didn't compute
+	// one.
+	RingHash string
+}
+
+// GetSingleHashRing fetches and decodes the JSONRingType served by the
+// buckyd daemon at hostport.
+func GetSingleHashRing(hostport string) (*JSONRingType, error) {
+	url := fmt.Sprintf("http://%s/hashring", hostport)
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GetSingleHashRing: %s: server returned HTTP %d", hostport, resp.StatusCode)
+	}
+
+	ring := new(JSONRingType)
+	if err := json.NewDecoder(resp.Body).Decode(ring); err != nil {
+		return nil, fmt.Errorf("GetSingleHashRing: %s: %s", hostport, err)
+	}
+
+	return ring, nil
+}